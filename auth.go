@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Command-line flags controlling persistent nickname registration, mirroring
+// goircd's -passwd/-perm-state-file design.
+var (
+	stateDirFlag  = flag.String("state-dir", "state", "directory holding per-nick registration files")
+	statePermFlag = flag.String("perm-state-file", "0600", "octal file permission mode for registration files")
+
+	stateFileMode os.FileMode = 0600
+)
+
+// initAuthState parses the permission flag and ensures the state directory
+// exists. It must run after flag.Parse().
+func initAuthState() {
+	perm, err := strconv.ParseUint(*statePermFlag, 8, 32)
+	if err != nil {
+		log.Fatal("Invalid -perm-state-file value:", err)
+	}
+	stateFileMode = os.FileMode(perm)
+
+	if err := os.MkdirAll(*stateDirFlag, 0700); err != nil {
+		log.Fatal("Failed to create state directory:", err)
+	}
+}
+
+func nickStatePath(nick string) string {
+	return filepath.Join(*stateDirFlag, strings.ToLower(nick)+".passwd")
+}
+
+// isNickRegistered reports whether nick has a stored password hash.
+func isNickRegistered(nick string) bool {
+	_, err := os.Stat(nickStatePath(nick))
+	return err == nil
+}
+
+// registerNick stores the SHA-256 hash of password for nick.
+func registerNick(nick, password string) error {
+	hash := sha256.Sum256([]byte(password))
+	return os.WriteFile(nickStatePath(nick), []byte(hex.EncodeToString(hash[:])), stateFileMode)
+}
+
+// verifyNickPassword reports whether password matches the hash stored for
+// nick, comparing in constant time to avoid timing side channels.
+func verifyNickPassword(nick, password string) bool {
+	stored, err := os.ReadFile(nickStatePath(nick))
+	if err != nil {
+		return false
+	}
+	hash := sha256.Sum256([]byte(password))
+	want := hex.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(string(stored))), []byte(want)) == 1
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// protocol identifies which wire format a connected client is speaking.
+// It is decided once, from the very first line the connection sends.
+type protocol int
+
+const (
+	protoLegacy protocol = iota // the original "/NICK"-style custom commands
+	protoIRC                    // RFC 1459/2812 style commands
+)
+
+// outBufSize bounds how many queued lines a slow client is allowed to fall
+// behind by before it is disconnected, matching goircd's MaxOutBuf.
+const outBufSize = 128
+
+var errClientDisconnected = errors.New("client disconnected")
+
+// Client holds all per-connection state for a single connected chat user.
+// It replaces the bare net.Conn-keyed maps used previously so that
+// nickname, protocol and buffering concerns all live in one place.
+//
+// Every Client owns a dedicated writer goroutine (writeLoop) reading off
+// outBuf, so producers never block on - or hold clientsLock across - a
+// network write. A client whose outBuf fills up is presumed stuck and is
+// disconnected rather than allowed to stall the sender.
+type Client struct {
+	conn     net.Conn
+	proto    protocol
+	nickname string
+	username string // set from IRC USER, empty for legacy clients
+	realname string
+
+	outBuf chan string
+	done   chan struct{}
+
+	deadMu sync.Mutex
+	dead   bool
+
+	// Idle/keepalive state, see idle.go. lastActivity lets the keepalive
+	// callbacks re-check liveness instead of trusting that they were never
+	// racing a concurrent resetIdleTimer.
+	timerMu      sync.Mutex
+	idleTimer    *time.Timer
+	quitTimer    *time.Timer
+	lastActivity time.Time
+
+	// Rooms this client has JOINed, see room.go.
+	roomsMu     sync.Mutex
+	joinedRooms map[string]bool
+
+	// authenticated is true for guest (unregistered) nicknames and for
+	// registered nicknames after a successful /IDENTIFY, see auth.go.
+	authenticated bool
+
+	// gotNick/gotUser/welcomed track IRC registration handshake progress.
+	// A connection's nickname is never empty (handleClient pre-assigns a
+	// Guest nick), so gotNick - not nickname == "" - is what tracks whether
+	// an explicit NICK has been sent; welcomed guards the welcome burst
+	// from firing more than once. See irc.go.
+	gotNick  bool
+	gotUser  bool
+	welcomed bool
+}
+
+func newClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:          conn,
+		outBuf:        make(chan string, outBufSize),
+		done:          make(chan struct{}),
+		joinedRooms:   make(map[string]bool),
+		authenticated: true, // guest nicknames need no authentication
+	}
+	go c.writeLoop()
+	return c
+}
+
+// writeLoop is the client's dedicated writer goroutine: the only thing that
+// ever writes to conn. It exits once disconnect closes done.
+func (c *Client) writeLoop() {
+	writer := bufio.NewWriter(c.conn)
+	for {
+		select {
+		case line := <-c.outBuf:
+			terminator := newline
+			if c.proto == protoIRC {
+				terminator = "\r\n"
+			}
+			if _, err := writer.WriteString(line + terminator); err != nil {
+				c.disconnect()
+				return
+			}
+			if err := writer.Flush(); err != nil {
+				c.disconnect()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// disconnect closes the connection and stops writeLoop. It is safe to call
+// more than once or from multiple goroutines.
+func (c *Client) disconnect() {
+	c.deadMu.Lock()
+	alreadyDead := c.dead
+	c.dead = true
+	c.deadMu.Unlock()
+
+	if alreadyDead {
+		return
+	}
+	close(c.done)
+	c.conn.Close()
+}
+
+// hasJoined reports whether the client has JOINed the named room.
+func (c *Client) hasJoined(room string) bool {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	return c.joinedRooms[room]
+}
+
+func (c *Client) markJoined(room string) {
+	c.roomsMu.Lock()
+	c.joinedRooms[room] = true
+	c.roomsMu.Unlock()
+}
+
+func (c *Client) markParted(room string) {
+	c.roomsMu.Lock()
+	delete(c.joinedRooms, room)
+	c.roomsMu.Unlock()
+}
+
+// send queues a single line for delivery to the client; writeLoop appends
+// the terminator its protocol expects. The enqueue is non-blocking: a
+// client whose outBuf is full is considered dead and disconnected rather
+// than stalling the caller (and, with it, every other client waiting on
+// the same lock during a broadcast fan-out).
+func (c *Client) send(line string) error {
+	c.deadMu.Lock()
+	dead := c.dead
+	c.deadMu.Unlock()
+	if dead {
+		return errClientDisconnected
+	}
+
+	select {
+	case c.outBuf <- line:
+		return nil
+	default:
+		fmt.Println("Output buffer full, disconnecting slow client:", c.nickname)
+		c.disconnect()
+		return errClientDisconnected
+	}
+}
+
+// sendIRC formats params as a server-originated IRC message and sends it.
+func (c *Client) sendIRC(command string, params ...string) error {
+	return c.send(formatIRCLine(serverName, command, params...))
+}
+
+// displayNick returns the client's nickname, or the IRC placeholder "*"
+// used in numeric replies before an explicit NICK has been received.
+func (c *Client) displayNick() string {
+	if !c.gotNick {
+		return "*"
+	}
+	return c.nickname
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errPrivateHistoryDenied is sent back when a client asks for another
+// nick's private-message history instead of its own.
+const errPrivateHistoryDenied = "You can only /HISTORY your own private messages, and only once identified."
+
+// historySize is how many recent lines are retained per channel or private
+// conversation.
+const historySize = 200
+
+type historyKind int
+
+const (
+	historyKindRoom historyKind = iota
+	historyKindPrivate
+)
+
+// historyEntry is one recorded line of chat.
+type historyEntry struct {
+	Time   time.Time
+	Sender string
+	Target string
+	Text   string
+	Kind   historyKind
+}
+
+// historyBuffer is a fixed-size ring buffer of historyEntry, guarded by its
+// own mutex so producers (room broadcasts, private messages) and readers
+// (join replay, /HISTORY) can run independently of everything else.
+type historyBuffer struct {
+	mu      sync.Mutex
+	entries [historySize]historyEntry
+	next    int
+	count   int
+}
+
+func (h *historyBuffer) add(entry historyEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % historySize
+	if h.count < historySize {
+		h.count++
+	}
+}
+
+// last returns up to n of the most recent entries, oldest first. n <= 0
+// means "everything retained".
+func (h *historyBuffer) last(n int) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n <= 0 || n > h.count {
+		n = h.count
+	}
+	result := make([]historyEntry, n)
+	for i := 0; i < n; i++ {
+		idx := (h.next - n + i + historySize) % historySize
+		result[i] = h.entries[idx]
+	}
+	return result
+}
+
+var (
+	roomHistory    = make(map[string]*historyBuffer)
+	privateHistory = make(map[string]*historyBuffer) // keyed by lowercase nickname
+	historyLock    sync.Mutex
+)
+
+func getRoomHistory(name string) *historyBuffer {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	h, ok := roomHistory[name]
+	if !ok {
+		h = &historyBuffer{}
+		roomHistory[name] = h
+	}
+	return h
+}
+
+func getPrivateHistory(nick string) *historyBuffer {
+	key := strings.ToLower(nick)
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	h, ok := privateHistory[key]
+	if !ok {
+		h = &historyBuffer{}
+		privateHistory[key] = h
+	}
+	return h
+}
+
+// formatHistoryLine renders an entry the way replay and /HISTORY display it.
+func formatHistoryLine(e historyEntry) string {
+	return fmt.Sprintf("[%s] <%s> %s", e.Time.Format("15:04:05"), e.Sender, e.Text)
+}
+
+// replayHistory sends up to n of a buffer's recent entries to client, in
+// the order they originally happened. n <= 0 replays everything retained.
+// Entries are framed per the client's protocol: legacy clients get the
+// plain "[HH:MM:SS] <nick> text" line, IRC clients get it relayed as a
+// NOTICE from the original sender (NOTICE, not PRIVMSG, so replay never
+// triggers an auto-reply) since this server has no server-time tag to
+// carry the original timestamp out of band.
+func replayHistory(client *Client, h *historyBuffer, n int) {
+	for _, e := range h.last(n) {
+		if client.proto == protoIRC {
+			client.send(formatIRCLine(e.Sender, "NOTICE", e.Target,
+				fmt.Sprintf("[%s] %s", e.Time.Format("15:04:05"), e.Text)))
+		} else {
+			client.send(formatHistoryLine(e))
+		}
+	}
+}
+
+// canReplayPrivateHistory reports whether client may read the private
+// message history recorded for target: only the owning, identified nick,
+// since that history is the recipient's private-message inbox.
+func canReplayPrivateHistory(client *Client, target string) bool {
+	return client.authenticated && strings.EqualFold(client.nickname, target)
+}
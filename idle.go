@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Idle/keepalive tuning: mirrors the IdleTimeout/QuitTimeout pattern used by
+// ircd implementations such as oragono.
+const (
+	idleTimeout = 90 * time.Second
+	quitTimeout = 60 * time.Second
+)
+
+// resetIdleTimer is called after every line a client sends. It cancels any
+// pending keepalive PING/quit timer and schedules a fresh idle timer.
+func (c *Client) resetIdleTimer() {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	c.lastActivity = time.Now()
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	if c.quitTimer != nil {
+		c.quitTimer.Stop()
+	}
+	c.idleTimer = time.AfterFunc(idleTimeout, c.sendKeepalivePing)
+}
+
+// stopTimers cancels any pending idle/quit timers. Called once a client
+// disconnects so its timers don't fire against a closed connection.
+func (c *Client) stopTimers() {
+	c.timerMu.Lock()
+	defer c.timerMu.Unlock()
+
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	if c.quitTimer != nil {
+		c.quitTimer.Stop()
+	}
+}
+
+// sendKeepalivePing fires once a client has been silent for idleTimeout. It
+// sends a server PING carrying a random token and starts the quitTimeout
+// countdown; any traffic from the client (a PONG or otherwise) cancels the
+// countdown via resetIdleTimer.
+//
+// It can race a resetIdleTimer call that is already in flight when the
+// idle timer fires (Stop() on an already-fired timer is a no-op), so it
+// re-checks lastActivity first: if a line arrived right around the same
+// moment, resetIdleTimer already armed a fresh idleTimer and there is
+// nothing to do here.
+func (c *Client) sendKeepalivePing() {
+	c.timerMu.Lock()
+	idleFor := time.Since(c.lastActivity)
+	c.timerMu.Unlock()
+	if idleFor < idleTimeout {
+		return
+	}
+
+	token := fmt.Sprintf("%x", rand.Int63())
+	if c.proto == protoIRC {
+		c.sendIRC("PING", token)
+	} else {
+		c.send("PING " + token)
+	}
+
+	c.timerMu.Lock()
+	c.quitTimer = time.AfterFunc(quitTimeout, c.timeoutDisconnect)
+	c.timerMu.Unlock()
+}
+
+// timeoutDisconnect runs when a client hasn't responded to a keepalive PING
+// within quitTimeout. Like sendKeepalivePing, it re-checks lastActivity
+// first in case traffic arrived right as the quit timer fired, so an
+// active client is never dropped without warning. Otherwise it notifies
+// the other clients and closes the connection; the blocked ReadString in
+// handleClient then returns an error and handleClientDisconnect performs
+// the usual cleanup.
+func (c *Client) timeoutDisconnect() {
+	c.timerMu.Lock()
+	idleFor := time.Since(c.lastActivity)
+	c.timerMu.Unlock()
+	if idleFor < quitTimeout {
+		return
+	}
+
+	broadcastNotice(c.nickname+" has timed out.", c.conn)
+	c.disconnect()
+}
+
+// broadcastNotice sends a plain informational line to every connected
+// client except exclude (typically the client the notice is about).
+func broadcastNotice(text string, exclude net.Conn) {
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
+
+	for conn, client := range clients {
+		if conn == exclude {
+			continue
+		}
+		client.send(text)
+	}
+}
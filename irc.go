@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// handleIRCCommand dispatches a single parsed IRC message to its handler.
+// Only the subset of RFC 1459/2812 needed for basic interoperability with
+// graphical and terminal IRC clients (HexChat, irssi, WeeChat, ...) is
+// implemented; anything else gets a generic "unknown command" reply.
+func handleIRCCommand(client *Client, msg *ircMessage) {
+	switch msg.Command {
+	case "NICK":
+		ircNick(client, msg)
+	case "USER":
+		ircUser(client, msg)
+	case "JOIN":
+		ircJoin(client, msg)
+	case "PART":
+		ircPart(client, msg)
+	case "PRIVMSG":
+		ircPrivmsg(client, msg, false)
+	case "NOTICE":
+		ircPrivmsg(client, msg, true)
+	case "QUIT":
+		ircQuit(client, msg)
+	case "PING":
+		ircPing(client, msg)
+	case "PONG":
+		// Keepalive acknowledgement; tracked once idle timers exist.
+	case "NAMES":
+		ircNames(client, msg)
+	case "WHO":
+		ircWho(client, msg)
+	case "TOPIC":
+		ircTopic(client, msg)
+	case "MODE":
+		ircMode(client, msg)
+	case "HISTORY":
+		ircHistory(client, msg)
+	case "IDENTIFY":
+		ircIdentify(client, msg)
+	case "CAP":
+		ircCap(client, msg)
+	case "":
+		// Blank line, ignore.
+	default:
+		client.sendIRC("421", client.displayNick(), msg.Command, "Unknown command")
+	}
+}
+
+func ircNick(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		client.sendIRC("431", client.displayNick(), "No nickname given")
+		return
+	}
+	newNick := msg.Params[0]
+	if err := validateNickname(newNick); err != nil {
+		client.sendIRC("432", client.displayNick(), newNick, err.Error())
+		return
+	}
+	if !isNicknameAvailable(newNick) {
+		client.sendIRC("433", client.displayNick(), newNick, "Nickname is already in use")
+		return
+	}
+
+	hadNick := client.gotNick
+	client.nickname = newNick
+	client.gotNick = true
+	client.authenticated = !isNickRegistered(newNick)
+
+	clientsLock.Lock()
+	clients[client.conn] = client
+	clientsLock.Unlock()
+
+	if !hadNick {
+		client.maybeSendWelcomeBurst()
+	} else {
+		client.sendIRC("NICK", newNick)
+	}
+
+	if !client.authenticated {
+		client.sendIRC("NOTICE", newNick, "Nickname "+newNick+" is registered; use IDENTIFY <password> to authenticate.")
+	}
+}
+
+// ircIdentify implements the IRC-side equivalent of the legacy /IDENTIFY
+// command, letting a client that claimed a registered nick over NICK prove
+// ownership of it and regain authenticated status.
+func ircIdentify(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		client.sendIRC("461", client.displayNick(), "IDENTIFY", "Not enough parameters")
+		return
+	}
+	password := msg.Params[0]
+	if !isNickRegistered(client.nickname) {
+		client.sendIRC("NOTICE", client.nickname, "Nickname '"+client.nickname+"' is not registered.")
+		return
+	}
+	if !verifyNickPassword(client.nickname, password) {
+		client.sendIRC("NOTICE", client.nickname, "Password incorrect.")
+		return
+	}
+	client.authenticated = true
+	client.sendIRC("NOTICE", client.nickname, "Identified as '"+client.nickname+"'.")
+	replayHistory(client, getPrivateHistory(client.nickname), 0)
+}
+
+func ircUser(client *Client, msg *ircMessage) {
+	if len(msg.Params) < 4 {
+		client.sendIRC("461", client.displayNick(), "USER", "Not enough parameters")
+		return
+	}
+	client.username = msg.Params[0]
+	client.realname = msg.Params[3]
+	client.gotUser = true
+	client.maybeSendWelcomeBurst()
+}
+
+// sendIRCWelcomeBurst sends the minimal 001/376 pair clients expect once
+// both NICK and USER have been received, regardless of arrival order.
+func sendIRCWelcomeBurst(client *Client) {
+	client.sendIRC("001", client.nickname, "Welcome to the chat server, "+client.nickname)
+	client.sendIRC("376", client.nickname, "End of MOTD command")
+}
+
+// maybeSendWelcomeBurst fires the welcome burst exactly once, the first
+// time both an explicit NICK and USER have been received. Connections
+// start with a server-assigned Guest nickname already set, so gotNick -
+// not an empty client.nickname - is what tracks whether NICK was ever
+// sent explicitly.
+func (c *Client) maybeSendWelcomeBurst() {
+	if c.welcomed || !c.gotNick || !c.gotUser {
+		return
+	}
+	c.welcomed = true
+	sendIRCWelcomeBurst(c)
+}
+
+// ircCap implements just enough of IRCv3 capability negotiation (CAP LS/
+// LIST/REQ/END) to stop real clients like HexChat/irssi - which send CAP
+// LS before NICK/USER - from getting a 421 Unknown command. This server
+// advertises no capabilities.
+func ircCap(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		return
+	}
+	switch strings.ToUpper(msg.Params[0]) {
+	case "LS", "LIST":
+		client.sendIRC("CAP", client.displayNick(), "LS", "")
+	case "REQ":
+		requested := ""
+		if len(msg.Params) > 1 {
+			requested = msg.Params[len(msg.Params)-1]
+		}
+		client.sendIRC("CAP", client.displayNick(), "NAK", requested)
+	case "END":
+		// Client is done negotiating; nothing to do.
+	}
+}
+
+func ircJoin(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		client.sendIRC("461", client.displayNick(), "JOIN", "Not enough parameters")
+		return
+	}
+	for _, name := range strings.Split(msg.Params[0], ",") {
+		joinRoom(name, client)
+		client.sendIRC("JOIN", name)
+	}
+}
+
+func ircPart(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		client.sendIRC("461", client.displayNick(), "PART", "Not enough parameters")
+		return
+	}
+	for _, name := range strings.Split(msg.Params[0], ",") {
+		partRoom(name, client)
+		client.sendIRC("PART", name)
+	}
+}
+
+func ircPrivmsg(client *Client, msg *ircMessage, notice bool) {
+	if len(msg.Params) < 2 {
+		if !notice {
+			client.sendIRC("411", client.displayNick(), "No recipient given")
+		}
+		return
+	}
+	if !client.authenticated {
+		if !notice {
+			client.sendIRC("NOTICE", client.nickname, "You must IDENTIFY <password> before using this command.")
+		}
+		return
+	}
+	target := msg.Params[0]
+	text := msg.Params[len(msg.Params)-1]
+
+	if strings.HasPrefix(target, "#") {
+		sendToRoom(target, text, client)
+		return
+	}
+	sendPrivateMessage(target, text, client.nickname, client.conn)
+}
+
+func ircQuit(client *Client, msg *ircMessage) {
+	reason := "Client quit"
+	if len(msg.Params) > 0 {
+		reason = msg.Params[len(msg.Params)-1]
+	}
+	client.sendIRC("ERROR", "Closing Link: "+reason)
+	// Close the raw connection (rather than calling disconnect directly) so
+	// writeLoop gets a chance to flush the queued ERROR line first; the
+	// resulting write error then drives the usual disconnect cleanup.
+	client.conn.Close()
+}
+
+func ircPing(client *Client, msg *ircMessage) {
+	token := serverName
+	if len(msg.Params) > 0 {
+		token = msg.Params[0]
+	}
+	client.send(fmt.Sprintf("PONG %s :%s", serverName, token))
+}
+
+func ircNames(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		return
+	}
+	for _, name := range strings.Split(msg.Params[0], ",") {
+		names := roomMemberNames(name)
+		client.sendIRC("353", client.nickname, "=", name, strings.Join(names, " "))
+		client.sendIRC("366", client.nickname, name, "End of NAMES list")
+	}
+}
+
+func ircWho(client *Client, msg *ircMessage) {
+	target := ""
+	if len(msg.Params) > 0 {
+		target = msg.Params[0]
+	}
+	client.sendIRC("315", client.nickname, target, "End of WHO list")
+}
+
+func ircTopic(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		client.sendIRC("461", client.displayNick(), "TOPIC", "Not enough parameters")
+		return
+	}
+	name := msg.Params[0]
+	if len(msg.Params) == 1 {
+		topic := roomTopic(name)
+		if topic == "" {
+			client.sendIRC("331", client.nickname, name, "No topic is set")
+		} else {
+			client.sendIRC("332", client.nickname, name, topic)
+		}
+		return
+	}
+	setRoomTopic(name, msg.Params[len(msg.Params)-1], client)
+}
+
+// ircHistory implements the non-standard HISTORY extension: HISTORY
+// <#room|nick> [N] replays up to N recent lines (20 by default).
+func ircHistory(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		client.sendIRC("461", client.displayNick(), "HISTORY", "Not enough parameters")
+		return
+	}
+
+	target := msg.Params[0]
+	if !strings.HasPrefix(target, "#") && !canReplayPrivateHistory(client, target) {
+		client.sendIRC("NOTICE", client.nickname, errPrivateHistoryDenied)
+		return
+	}
+
+	n := 20
+	if len(msg.Params) > 1 {
+		if parsed, err := strconv.Atoi(msg.Params[1]); err == nil && parsed > 0 {
+			n = parsed
+		}
+	}
+	replayHistory(client, historyBufferFor(target), n)
+}
+
+func ircMode(client *Client, msg *ircMessage) {
+	if len(msg.Params) == 0 {
+		client.sendIRC("461", client.displayNick(), "MODE", "Not enough parameters")
+		return
+	}
+	// Channel/user modes are not modelled yet; acknowledge with none set.
+	client.sendIRC("324", client.nickname, msg.Params[0], "+")
+}
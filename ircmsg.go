@@ -0,0 +1,77 @@
+package main
+
+import "strings"
+
+// serverName is used as the prefix on every server-originated IRC message.
+const serverName = "go-chat.local"
+
+// ircMessage is a single parsed IRC protocol line in the
+// ":prefix COMMAND param0 param1 :trailing" format described by RFC 1459/2812.
+type ircMessage struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// parseIRCLine parses a raw IRC protocol line into its prefix, command and
+// parameters. The final parameter may be a "trailing" parameter introduced
+// by a leading colon, which is the only one allowed to contain spaces.
+func parseIRCLine(line string) *ircMessage {
+	msg := &ircMessage{}
+
+	if strings.HasPrefix(line, ":") {
+		parts := strings.SplitN(line, " ", 2)
+		msg.Prefix = strings.TrimPrefix(parts[0], ":")
+		if len(parts) < 2 {
+			return msg
+		}
+		line = parts[1]
+	}
+
+	rest := line
+	trailing := ""
+	hasTrailing := false
+	if idx := strings.Index(line, " :"); idx != -1 {
+		rest = line[:idx]
+		trailing = line[idx+2:]
+		hasTrailing = true
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		if hasTrailing {
+			msg.Params = append(msg.Params, trailing)
+		}
+		return msg
+	}
+
+	msg.Command = strings.ToUpper(fields[0])
+	msg.Params = append(msg.Params, fields[1:]...)
+	if hasTrailing {
+		msg.Params = append(msg.Params, trailing)
+	}
+	return msg
+}
+
+// formatIRCLine serializes a server-originated message in standard IRC wire
+// format, marking the final parameter as trailing (leading colon) whenever
+// it contains a space or is empty, since otherwise it would be ambiguous.
+func formatIRCLine(prefix, command string, params ...string) string {
+	var b strings.Builder
+	if prefix != "" {
+		b.WriteString(":")
+		b.WriteString(prefix)
+		b.WriteString(" ")
+	}
+	b.WriteString(command)
+
+	for i, p := range params {
+		b.WriteString(" ")
+		last := i == len(params)-1
+		if last && (p == "" || strings.Contains(p, " ") || strings.HasPrefix(p, ":")) {
+			b.WriteString(":")
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
@@ -2,26 +2,42 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // 클라이언트와 서버 간의 통신 프로토콜 정의
 const (
 	NickChangeCommand = "/NICK "
 	MessageCommand    = "/MSG "
+	ListCommand       = "/LIST"
+	BroadcastCommand  = "/BC "
+	JoinCommand       = "/JOIN "
+	PartCommand       = "/PART "
+	TopicCommand      = "/TOPIC "
+	NamesCommand      = "/NAMES "
+	RegisterCommand   = "/REGISTER "
+	IdentifyCommand   = "/IDENTIFY "
+	HistoryCommand    = "/HISTORY"
 )
 
 var (
-	clients     = make(map[net.Conn]string) // 연결된 클라이언트와 닉네임 매핑
-	clientsLock sync.Mutex                  // 클라이언트 매핑을 위한 뮤텍스
+	clients     = make(map[net.Conn]*Client) // 연결된 클라이언트와 상태 매핑
+	clientsLock sync.RWMutex                 // 클라이언트 매핑을 위한 뮤텍스 (동시 순회를 위해 RWMutex 사용)
 	newline     = "\n\r"
 )
 
 func main() {
+	flag.Parse()
+	initAuthState()
+
 	listener, err := net.Listen("tcp", "localhost:6666")
 	if err != nil {
 		log.Fatal("Failed to start server:", err)
@@ -45,120 +61,297 @@ func main() {
 func handleClient(conn net.Conn) {
 	defer conn.Close()
 
-	var nickname string
-	// var nicknameSet bool // 닉네임이 설정되었는지 여부를 나타내는 변수 추가
+	client := newClient(conn)
+	client.nickname = fmt.Sprintf("Guest%d", rand.Intn(10000))
+
+	clientsLock.Lock()
+	clients[conn] = client
+	clientsLock.Unlock()
+
+	reader := bufio.NewReader(conn)
 
 	// 클라이언트에게 연결 성공 메시지 안내 전송
-	welcomeMessage := "Welcome to the chat server! Use /NICK <nickname> to set your nickname.\n\r"
-	_, err := conn.Write([]byte(welcomeMessage))
-	if err != nil {
-		fmt.Println("Failed to send welcome message to client:", err)
-		return
-	}
+	client.send("Welcome to the chat server! You are " + client.nickname + ". Use /NICK <nickname> to set your nickname.")
+
+	client.resetIdleTimer()
 
+	detected := false
 	for {
 		// 클라이언트로부터 메시지를 받음
-		input, err := bufio.NewReader(conn).ReadString('\n')
+		input, err := reader.ReadString('\n')
 		if err != nil {
 			fmt.Println("Client disconnected:", err)
 			// 클라이언트가 연결을 종료한 경우 처리
-			handleClientDisconnect(conn, nickname)
+			handleClientDisconnect(client)
 			return
 		}
 
+		client.resetIdleTimer()
 		message := strings.TrimSpace(input)
 
-		if strings.HasPrefix(message, NickChangeCommand) {
-			// 닉네임 설정 시도
-			newNickname := strings.TrimPrefix(message, NickChangeCommand)
-			if isNicknameAvailable(newNickname) {
-				nickname = newNickname
-				// nicknameSet = true // 닉네임이 설정됨을 표시
-				// 닉네임 설정 성공 메시지 전송
-				response := "Your nickname is now set to: " + nickname + newline
-				_, err := conn.Write([]byte(response))
-				if err != nil {
-					fmt.Println("Failed to send nickname confirmation to client:", err)
-					return
-				}
-			} else {
-				// 닉네임이 이미 사용 중인 경우 메시지 전송
-				response := "Nickname already in use. Choose a different nickname." + newline
-				_, err := conn.Write([]byte(response))
-				if err != nil {
-					fmt.Println("Failed to send nickname in use message to client:", err)
-					return
-				}
-			}
-		} else if message == "/LIST" {
-			// 클라이언트 리스트와 닉네임 출력
-			clientList := getClientList()
-			response := "Client List:\n" + clientList + newline
-			_, err := conn.Write([]byte(response))
-			if err != nil {
-				fmt.Println("Failed to send client list to client:", err)
+		// 첫 줄을 보고 레거시 "/" 명령과 표준 IRC 명령을 자동 구분한다
+		if !detected {
+			detected = true
+			client.proto = detectProtocol(message)
+		}
+
+		if client.proto == protoIRC {
+			handleIRCCommand(client, parseIRCLine(message))
+			continue
+		}
+
+		handleLegacyLine(client, message)
+	}
+}
+
+// detectProtocol looks at the very first line a connection sends to decide
+// whether it is one of this server's own "/"-prefixed clients or a real IRC
+// client: IRC clients open with NICK/USER/CAP and never use "/" commands.
+func detectProtocol(firstLine string) protocol {
+	if strings.HasPrefix(firstLine, "/") {
+		return protoLegacy
+	}
+
+	fields := strings.Fields(firstLine)
+	if len(fields) == 0 {
+		return protoLegacy
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "NICK", "USER", "CAP", "PASS", "JOIN", "PART", "PRIVMSG", "NOTICE",
+		"QUIT", "PING", "PONG", "NAMES", "WHO", "TOPIC", "MODE":
+		return protoIRC
+	default:
+		return protoLegacy
+	}
+}
+
+// handleLegacyLine processes one line of this server's original "/NICK",
+// "/MSG", "/LIST", "/BC" command set.
+func handleLegacyLine(client *Client, message string) {
+	conn := client.conn
+
+	if strings.HasPrefix(message, NickChangeCommand) {
+		// 닉네임 설정 시도
+		newNickname := strings.TrimPrefix(message, NickChangeCommand)
+		if err := validateNickname(newNickname); err != nil {
+			client.send(err.Error())
+			return
+		}
+		if !isNicknameAvailable(newNickname) {
+			// 닉네임이 이미 사용 중인 경우 메시지 전송
+			client.send("Nickname already in use. Choose a different nickname.")
+			return
+		}
+
+		client.nickname = newNickname
+		client.authenticated = !isNickRegistered(newNickname)
+
+		clientsLock.Lock()
+		clients[conn] = client
+		clientsLock.Unlock()
+
+		if client.authenticated {
+			client.send("Your nickname is now set to: " + client.nickname)
+		} else {
+			client.send("Nickname '" + client.nickname + "' is registered. Use /IDENTIFY <password> to authenticate.")
+		}
+	} else if strings.HasPrefix(message, RegisterCommand) {
+		password := strings.TrimPrefix(message, RegisterCommand)
+		if isNickRegistered(client.nickname) {
+			client.send("Nickname '" + client.nickname + "' is already registered.")
+			return
+		}
+		if err := registerNick(client.nickname, password); err != nil {
+			fmt.Println("Failed to register nickname:", err)
+			client.send("Failed to register nickname, try again later.")
+			return
+		}
+		client.authenticated = true
+		client.send("Nickname '" + client.nickname + "' is now registered.")
+	} else if strings.HasPrefix(message, IdentifyCommand) {
+		password := strings.TrimPrefix(message, IdentifyCommand)
+		if !isNickRegistered(client.nickname) {
+			client.send("Nickname '" + client.nickname + "' is not registered.")
+			return
+		}
+		if verifyNickPassword(client.nickname, password) {
+			client.authenticated = true
+			client.send("Identified as '" + client.nickname + "'.")
+			replayHistory(client, getPrivateHistory(client.nickname), 0)
+		} else {
+			client.send("Password incorrect.")
+		}
+	} else if message == ListCommand {
+		if !client.authenticated {
+			client.send("You must /IDENTIFY <password> before using this command.")
+			return
+		}
+		// 클라이언트 리스트와 닉네임 출력
+		client.send("Client List:\n" + getClientList())
+	} else if strings.HasPrefix(message, BroadcastCommand) {
+		if !client.authenticated {
+			client.send("You must /IDENTIFY <password> before using this command.")
+			return
+		}
+		body := strings.TrimPrefix(message, BroadcastCommand)
+		if strings.HasPrefix(body, "#") {
+			parts := strings.SplitN(body, " ", 2)
+			if len(parts) != 2 {
+				client.send("Usage: /BC #room <message>")
 				return
 			}
+			if sendToRoom(parts[0], parts[1], client) {
+				client.send("You (" + parts[0] + "): " + parts[1])
+			}
 		} else {
-			// 닉네임 설정 완료된 후에는 다른 메시지를 처리 (예: 브로드캐스트)
-			// 이 부분에 메시지 처리 로직을 추가하세요.
-			// broadcastMessage 함수를 호출하거나 다른 로직을 구현하여 메시지를 처리합니다.
-			// 예: broadcastMessage(message, nickname, conn)
+			broadcastMessage(body, client.nickname, conn)
+		}
+	} else if strings.HasPrefix(message, MessageCommand) {
+		if !client.authenticated {
+			client.send("You must /IDENTIFY <password> before using this command.")
+			return
+		}
+		parts := strings.SplitN(strings.TrimPrefix(message, MessageCommand), " ", 2)
+		if len(parts) != 2 {
+			client.send("Usage: /MSG <nickname> <message>")
+			return
 		}
+		sendPrivateMessage(parts[0], parts[1], client.nickname, conn)
+	} else if strings.HasPrefix(message, JoinCommand) {
+		room := strings.TrimPrefix(message, JoinCommand)
+		joinRoom(room, client)
+		client.send("Joined " + room)
+	} else if strings.HasPrefix(message, PartCommand) {
+		room := strings.TrimPrefix(message, PartCommand)
+		partRoom(room, client)
+		client.send("Left " + room)
+	} else if strings.HasPrefix(message, TopicCommand) {
+		parts := strings.SplitN(strings.TrimPrefix(message, TopicCommand), " ", 2)
+		if len(parts) != 2 {
+			client.send("Usage: /TOPIC #room <text>")
+			return
+		}
+		if setRoomTopic(parts[0], parts[1], client) {
+			client.send("Topic set on " + parts[0])
+		}
+	} else if strings.HasPrefix(message, NamesCommand) {
+		room := strings.TrimPrefix(message, NamesCommand)
+		client.send("Members of " + room + ": " + strings.Join(roomMemberNames(room), ", "))
+	} else if message == HistoryCommand || strings.HasPrefix(message, HistoryCommand+" ") {
+		args := strings.Fields(strings.TrimPrefix(message, HistoryCommand))
+		if len(args) == 0 {
+			client.send("Usage: /HISTORY <#room|nick> [N]")
+			return
+		}
+		if !strings.HasPrefix(args[0], "#") && !canReplayPrivateHistory(client, args[0]) {
+			client.send(errPrivateHistoryDenied)
+			return
+		}
+		replayHistory(client, historyBufferFor(args[0]), parseHistoryCount(args))
+	}
+}
+
+// historyBufferFor resolves a /HISTORY target to the room or private-
+// conversation ring buffer it refers to.
+func historyBufferFor(target string) *historyBuffer {
+	if strings.HasPrefix(target, "#") {
+		return getRoomHistory(target)
 	}
+	return getPrivateHistory(target)
+}
+
+// parseHistoryCount reads the optional N argument to /HISTORY, defaulting
+// to 20 recent entries when absent or invalid.
+func parseHistoryCount(args []string) int {
+	if len(args) < 2 {
+		return 20
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 {
+		return 20
+	}
+	return n
 }
 
 func isNicknameAvailable(nickname string) bool {
-	clientsLock.Lock()
-	defer clientsLock.Unlock()
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
 
-	for _, existingNickname := range clients {
-		if existingNickname == nickname {
+	for _, existingClient := range clients {
+		if existingClient.nickname == nickname {
 			return false
 		}
 	}
 	return true
 }
 
-func handleClientDisconnect(conn net.Conn, nickname string) {
+func handleClientDisconnect(client *Client) {
+	client.stopTimers()
+	client.disconnect()
+
 	clientsLock.Lock()
 	defer clientsLock.Unlock()
 
-	delete(clients, conn)
-	fmt.Println("Client disconnected:", nickname)
+	delete(clients, client.conn)
+	fmt.Println("Client disconnected:", client.nickname)
 
 	// 클라이언트 연결 종료 후 처리 로직 추가 (예: 닉네임 해제)
 }
 
-// broadcastMessage 함수를 정의하여 메시지를 모든 클라이언트에게 전송할 수 있습니다.
+// broadcastMessage 함수를 정의하여 메시지를 모든 클라이언트에게 전송할 수 있습니다. 각
+// 클라이언트로의 전송은 그 클라이언트의 버퍼링된 writeLoop로 큐잉되므로, 느린 클라이언트
+// 하나가 이 순회 전체를 막지 않는다.
 func broadcastMessage(message, senderNickname string, senderConn net.Conn) {
-	clientsLock.Lock()
-	defer clientsLock.Unlock()
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
 
-	for clientConn, clientNickname := range clients {
-		if clientConn != nil && clientNickname != senderNickname {
-			_, err := clientConn.Write([]byte(senderNickname + ": " + message + newline))
-			if err != nil {
-				fmt.Println("Failed to send message to client:", err)
-			}
+	for clientConn, client := range clients {
+		if clientConn != nil && client.nickname != senderNickname {
+			client.send(senderNickname + ": " + message)
 		} else if clientConn == senderConn {
 			// 보낸 클라이언트에게도 메시지를 표시
-			_, err := clientConn.Write([]byte("You: " + message + newline))
-			if err != nil {
-				fmt.Println("Failed to send message to client:", err)
-			}
+			client.send("You: " + message)
+		}
+	}
+}
+
+func sendPrivateMessage(recipientNickname, message, senderNickname string, senderConn net.Conn) {
+	clientsLock.RLock()
+	delivered := false
+	for clientConn, client := range clients {
+		if clientConn != nil && client.nickname == recipientNickname {
+			client.send(senderNickname + " (private): " + message)
+			delivered = true
+			break
 		}
 	}
+	sender, senderKnown := clients[senderConn]
+	clientsLock.RUnlock()
+
+	// Recorded even when offline, so the recipient can /HISTORY or replay
+	// it after a future /IDENTIFY.
+	getPrivateHistory(recipientNickname).add(historyEntry{
+		Time:   time.Now(),
+		Sender: senderNickname,
+		Target: recipientNickname,
+		Text:   message,
+		Kind:   historyKindPrivate,
+	})
+
+	if !delivered && senderKnown {
+		sender.send("User " + recipientNickname + " not found or offline.")
+	}
 }
 
 // 클라이언트 리스트와 닉네임을 가져오는 함수
 func getClientList() string {
-	clientsLock.Lock()
-	defer clientsLock.Unlock()
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
 
 	clientList := ""
-	for _, clientNickname := range clients {
-		clientList += clientNickname + "\n"
+	for _, client := range clients {
+		clientList += client.nickname + "\n"
 	}
 	return clientList
 }
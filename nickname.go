@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// nicknamePattern mirrors the shape goircd's RENickname validates: letters,
+// digits and a small set of punctuation IRC nicknames commonly allow, up to
+// 24 characters.
+var nicknamePattern = regexp.MustCompile(`^[a-zA-Z0-9_\-\[\]{}\\|]{1,24}$`)
+
+// reservedNicknames may never be claimed by a client, regardless of whether
+// they are otherwise free, since they are reserved for the server itself.
+var reservedNicknames = map[string]bool{
+	"server":   true,
+	"admin":    true,
+	"root":     true,
+	"nickserv": true,
+	"chanserv": true,
+}
+
+var (
+	errNicknameEmpty            = errors.New("nickname must not be empty")
+	errNicknameStartsWithDigit  = errors.New("nickname must not start with a digit")
+	errNicknameInvalidChars     = errors.New("nickname may only contain letters, digits and _-[]{}\\|, up to 24 characters")
+	errNicknameHasCommandPrefix = errors.New("nickname must not contain the command prefix '/'")
+	errNicknameReserved         = errors.New("nickname is reserved")
+)
+
+// validateNickname checks a requested nickname against this server's naming
+// policy. It returns a distinct error for each rejection reason so a client
+// can react to (or display) the specific problem.
+func validateNickname(nickname string) error {
+	if nickname == "" {
+		return errNicknameEmpty
+	}
+	if nickname[0] >= '0' && nickname[0] <= '9' {
+		return errNicknameStartsWithDigit
+	}
+	if strings.Contains(nickname, "/") {
+		return errNicknameHasCommandPrefix
+	}
+	if !nicknamePattern.MatchString(nickname) {
+		return errNicknameInvalidChars
+	}
+	if reservedNicknames[strings.ToLower(nickname)] {
+		return errNicknameReserved
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// roomEventKind identifies what a roomEvent asks a Room's loop to do.
+type roomEventKind int
+
+const (
+	roomEventJoin roomEventKind = iota
+	roomEventPart
+	roomEventTopic
+	roomEventMessage
+	roomEventNames
+	roomEventGetTopic
+)
+
+// roomEvent is sent to a Room's recv channel; reply is only set for the
+// query kinds (roomEventNames, roomEventGetTopic).
+type roomEvent struct {
+	kind  roomEventKind
+	from  *Client
+	text  string
+	reply chan roomQueryResult
+}
+
+type roomQueryResult struct {
+	names []string
+	topic string
+}
+
+// Room is a named channel that clients can JOIN, PART, set the TOPIC of and
+// send messages to. Each Room owns a single goroutine (loop) that is the
+// only thing allowed to touch members/topic, so callers talk to it only by
+// sending roomEvents on recv - following the ircd Channel.loop pattern.
+type Room struct {
+	name    string
+	recv    chan *roomEvent
+	members map[*Client]bool
+	topic   string
+}
+
+var (
+	rooms     = make(map[string]*Room)
+	roomsLock sync.Mutex
+)
+
+// sendRoomEvent looks up name and enqueues ev on its recv channel while
+// holding roomsLock for the whole lookup-then-send, so the send can never
+// race the registry mutation a concurrent tryDestroy performs under the
+// same lock (the TOCTOU this replaced let a room be torn down between a
+// caller's lookup and its send). create controls whether a missing room is
+// created on demand (JOIN) or treated as not-found (everything else).
+//
+// The send itself is non-blocking, mirroring Client.send: a room whose
+// recv buffer (32) is already full is treated the same as a missing room
+// rather than blocking - while holding the single global roomsLock - until
+// its loop drains it, which would freeze every other room's callers too.
+func sendRoomEvent(name string, ev *roomEvent, create bool) bool {
+	roomsLock.Lock()
+	defer roomsLock.Unlock()
+
+	room, ok := rooms[name]
+	if !ok {
+		if !create {
+			return false
+		}
+		room = &Room{
+			name:    name,
+			recv:    make(chan *roomEvent, 32),
+			members: make(map[*Client]bool),
+		}
+		rooms[name] = room
+		go room.loop()
+	}
+
+	select {
+	case room.recv <- ev:
+		return true
+	default:
+		return false
+	}
+}
+
+// loop is the Room's owning goroutine; it is the only thing that ever
+// touches members/topic. It exits once the last member leaves and no
+// further event is pending, per tryDestroy.
+func (r *Room) loop() {
+	for ev := range r.recv {
+		r.handleEvent(ev)
+		if len(r.members) == 0 && r.tryDestroy() {
+			return
+		}
+	}
+}
+
+// tryDestroy decides, under roomsLock, whether the room is really done: it
+// drains any event that a sender enqueued concurrently with this check
+// (same lock as sendRoomEvent, so none can still be in flight once drained)
+// and only removes the room from the registry if members is still empty
+// afterwards. Returns whether the room was destroyed.
+func (r *Room) tryDestroy() bool {
+	roomsLock.Lock()
+	defer roomsLock.Unlock()
+
+	for {
+		select {
+		case ev := <-r.recv:
+			r.handleEvent(ev)
+		default:
+			if len(r.members) != 0 {
+				return false
+			}
+			delete(rooms, r.name)
+			return true
+		}
+	}
+}
+
+func (r *Room) handleEvent(ev *roomEvent) {
+	switch ev.kind {
+	case roomEventJoin:
+		r.members[ev.from] = true
+		ev.from.markJoined(r.name)
+		replayHistory(ev.from, getRoomHistory(r.name), 0)
+		r.broadcastExcept(ev.from,
+			formatIRCLine(ev.from.nickname, "JOIN", r.name),
+			ev.from.nickname+" has joined "+r.name)
+
+	case roomEventPart:
+		delete(r.members, ev.from)
+		ev.from.markParted(r.name)
+		r.broadcastExcept(ev.from,
+			formatIRCLine(ev.from.nickname, "PART", r.name),
+			ev.from.nickname+" has left "+r.name)
+
+	case roomEventTopic:
+		r.topic = ev.text
+		r.broadcastExcept(ev.from,
+			formatIRCLine(ev.from.nickname, "TOPIC", r.name, ev.text),
+			ev.from.nickname+" set the topic on "+r.name+": "+ev.text)
+
+	case roomEventMessage:
+		getRoomHistory(r.name).add(historyEntry{
+			Time:   time.Now(),
+			Sender: ev.from.nickname,
+			Target: r.name,
+			Text:   ev.text,
+			Kind:   historyKindRoom,
+		})
+		r.broadcastExcept(ev.from,
+			formatIRCLine(ev.from.nickname, "PRIVMSG", r.name, ev.text),
+			ev.from.nickname+"@"+r.name+": "+ev.text)
+
+	case roomEventNames:
+		names := make([]string, 0, len(r.members))
+		for m := range r.members {
+			names = append(names, m.nickname)
+		}
+		ev.reply <- roomQueryResult{names: names}
+
+	case roomEventGetTopic:
+		ev.reply <- roomQueryResult{topic: r.topic}
+	}
+}
+
+// broadcastExcept fans a message out to every member but exclude, sending
+// each one the line matching its own protocol.
+func (r *Room) broadcastExcept(exclude *Client, ircLine, plainLine string) {
+	for member := range r.members {
+		if member == exclude {
+			continue
+		}
+		if member.proto == protoIRC {
+			member.send(ircLine)
+		} else {
+			member.send(plainLine)
+		}
+	}
+}
+
+// joinRoom adds client to the named room, creating it if necessary.
+func joinRoom(name string, client *Client) {
+	sendRoomEvent(name, &roomEvent{kind: roomEventJoin, from: client}, true)
+}
+
+// partRoom removes client from the named room, if it exists.
+func partRoom(name string, client *Client) {
+	sendRoomEvent(name, &roomEvent{kind: roomEventPart, from: client}, false)
+}
+
+// sendToRoom delivers text to every other member of the named room, unless
+// sender has not joined it.
+func sendToRoom(name, text string, sender *Client) bool {
+	if !sender.hasJoined(name) {
+		notJoinedError(sender, name)
+		return false
+	}
+
+	if !sendRoomEvent(name, &roomEvent{kind: roomEventMessage, from: sender, text: text}, false) {
+		noSuchChannelError(sender, name)
+		return false
+	}
+	return true
+}
+
+func setRoomTopic(name, topic string, setter *Client) bool {
+	if !setter.hasJoined(name) {
+		notJoinedError(setter, name)
+		return false
+	}
+
+	if !sendRoomEvent(name, &roomEvent{kind: roomEventTopic, from: setter, text: topic}, false) {
+		noSuchChannelError(setter, name)
+		return false
+	}
+	return true
+}
+
+func roomMemberNames(name string) []string {
+	reply := make(chan roomQueryResult, 1)
+	if !sendRoomEvent(name, &roomEvent{kind: roomEventNames, reply: reply}, false) {
+		return nil
+	}
+	return (<-reply).names
+}
+
+func roomTopic(name string) string {
+	reply := make(chan roomQueryResult, 1)
+	if !sendRoomEvent(name, &roomEvent{kind: roomEventGetTopic, reply: reply}, false) {
+		return ""
+	}
+	return (<-reply).topic
+}
+
+func notJoinedError(client *Client, room string) {
+	if client.proto == protoIRC {
+		client.sendIRC("442", client.nickname, room, "You're not on that channel")
+	} else {
+		client.send("You must /JOIN " + room + " before sending messages there.")
+	}
+}
+
+func noSuchChannelError(client *Client, room string) {
+	if client.proto == protoIRC {
+		client.sendIRC("403", client.nickname, room, "No such channel")
+	} else {
+		client.send("No such channel: " + room)
+	}
+}